@@ -0,0 +1,36 @@
+package bulldozer
+
+import (
+	"context"
+	"time"
+
+	"github.com/ericnorris/bulldozer/internal/statemachine"
+)
+
+type BlueGreenCmd struct {
+	ProjectID     string        `kong:"required,help='Google Cloud Platform project ID.'"`
+	Region        string        `kong:"xor='location',help='Region of the regional managed instance group.'"`
+	Zone          string        `kong:"xor='location',help='Zone of the zonal managed instance group.'"`
+	InstanceGroup string        `kong:"required,help='Name of the managed instance group'"`
+	Template      string        `kong:"required,help='Name of the instance template to deploy.'"`
+	Soak          time.Duration `kong:"default='5m',help='How long to observe the green instance group for regressions before tearing down the old one.'"`
+	KeepOldMIG    bool          `kong:"help='Keep the old (blue) instance group around after cutover, for manual rollback.'"`
+}
+
+func (c *BlueGreenCmd) Run(ctx context.Context) error {
+	location, err := resolveLocation(c.Region, c.Zone)
+
+	if err != nil {
+		return err
+	}
+
+	runner, err := statemachine.NewBlueGreen(
+		ctx, c.ProjectID, location, c.InstanceGroup, c.Template, c.Soak, c.KeepOldMIG,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return runner.Start(ctx)
+}
@@ -0,0 +1,8 @@
+package bulldozer
+
+type Cmd struct {
+	Start     StartCmd     `kong:"cmd,help='Start a canary rollout of a new instance template.'"`
+	BlueGreen BlueGreenCmd `kong:"cmd,help='Run a blue/green deployment with an atomic traffic cutover.'"`
+	GKE       GKECmd       `kong:"cmd,help='Roll out a new instance template to a GKE node pool.'"`
+	Resume    ResumeCmd    `kong:"cmd,help='Resume a rollout from a checkpointed state file.'"`
+}
@@ -0,0 +1,18 @@
+package bulldozer
+
+import (
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+// TestCmd_KongGrammarIsValid builds the kong parser for the full CLI tree.
+// kong.New validates every kong struct tag while building the grammar (e.g.
+// it would reject the ProjectID field's help text if it weren't properly
+// quoted), so this catches a broken tag before it reaches a user as a
+// runtime panic on every subcommand.
+func TestCmd_KongGrammarIsValid(t *testing.T) {
+	if _, err := kong.New(&Cmd{}); err != nil {
+		t.Fatalf("kong.New(&Cmd{}) returned an error, the CLI grammar is broken: %s", err)
+	}
+}
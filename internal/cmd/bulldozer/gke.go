@@ -0,0 +1,35 @@
+package bulldozer
+
+import (
+	"context"
+
+	"github.com/ericnorris/bulldozer/internal/statemachine"
+)
+
+type GKECmd struct {
+	ProjectID string `kong:"required,help='Google Cloud Platform project ID.'"`
+	Cluster   string `kong:"required,help='Name of the GKE cluster.'"`
+	Location  string `kong:"required,help='Location (region or zone) of the GKE cluster.'"`
+	NodePool  string `kong:"required,name='node-pool',help='Name of the node pool to roll out to.'"`
+	Template  string `kong:"required,help='Name of the instance template to deploy.'"`
+	Strategy  string `kong:"default='instances',enum='instances,traffic',help='Rollout strategy to use: instances grows the canary instance count within the managed instance group, traffic keeps the canary at a fixed size and progressively shifts load balancer traffic to it.'"`
+	Plan      string `kong:"required,type='path',help='Path to a YAML rollout plan describing canary steps, analysis windows, and rollback behavior.'"`
+}
+
+func (c *GKECmd) Run(ctx context.Context) error {
+	plan, err := statemachine.LoadPlan(c.Plan)
+
+	if err != nil {
+		return err
+	}
+
+	runner, err := statemachine.NewFromNodePool(
+		ctx, c.ProjectID, c.Cluster, c.Location, c.NodePool, c.Template, statemachine.Strategy(c.Strategy), plan,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return runner.Start(ctx)
+}
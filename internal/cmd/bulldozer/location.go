@@ -0,0 +1,52 @@
+package bulldozer
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/ericnorris/bulldozer/internal/statemachine"
+)
+
+// resolveLocation builds a statemachine.RegionOrZone from the --region and
+// --zone flags, exactly one of which must be set.
+func resolveLocation(region, zone string) (statemachine.RegionOrZone, error) {
+	switch {
+	case region != "" && zone != "":
+		return statemachine.RegionOrZone{}, errors.New("only one of --region or --zone may be specified")
+
+	case region != "":
+		return statemachine.Region(region), nil
+
+	case zone != "":
+		return statemachine.Zone(zone), nil
+
+	default:
+		return statemachine.RegionOrZone{}, errors.New("one of --region or --zone is required")
+	}
+}
+
+// resolveStateStore builds a statemachine.StateStore from the --state-file
+// and --state-gcs flags, at most one of which may be set. It returns a nil
+// store and no error when neither flag is given.
+func resolveStateStore(ctx context.Context, file, gcsPath string) (statemachine.StateStore, error) {
+	switch {
+	case file != "" && gcsPath != "":
+		return nil, errors.New("only one of --state-file or --state-gcs may be specified")
+
+	case file != "":
+		return statemachine.NewLocalFileStateStore(file), nil
+
+	case gcsPath != "":
+		bucket, object, err := statemachine.ParseGCSPath(gcsPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return statemachine.NewGCSStateStore(ctx, bucket, object)
+
+	default:
+		return nil, nil
+	}
+}
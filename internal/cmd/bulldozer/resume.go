@@ -0,0 +1,42 @@
+package bulldozer
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/ericnorris/bulldozer/internal/statemachine"
+)
+
+type ResumeCmd struct {
+	StateFile string `kong:"xor='state',help='Path to the local rollout state file to resume from.'"`
+	StateGCS  string `kong:"xor='state',help='gs://bucket/object path to the rollout state to resume from.'"`
+	Plan      string `kong:"required,type='path',help='Path to the YAML rollout plan used for the original rollout.'"`
+	Force     bool   `kong:"help='Resume even if the instance group has drifted from the recorded checkpoint.'"`
+}
+
+func (c *ResumeCmd) Run(ctx context.Context) error {
+	stateStore, err := resolveStateStore(ctx, c.StateFile, c.StateGCS)
+
+	if err != nil {
+		return err
+	}
+
+	if stateStore == nil {
+		return errors.New("one of --state-file or --state-gcs is required")
+	}
+
+	plan, err := statemachine.LoadPlan(c.Plan)
+
+	if err != nil {
+		return err
+	}
+
+	runner, err := statemachine.Resume(ctx, stateStore, plan, c.Force)
+
+	if err != nil {
+		return err
+	}
+
+	return runner.Start(ctx)
+}
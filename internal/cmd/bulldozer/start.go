@@ -7,18 +7,53 @@ import (
 )
 
 type StartCmd struct {
-	ProjectID     string `kong:"required,help="Google Cloud Platform project ID."`
-	Region        string `kong:"required,help='Region of the regional managed instance group.'"`
-	InstanceGroup string `kong:"required,help='Name of the regional managed instance group'"`
-	Template      string `kong:"required,help='Name of the instance template to deploy.'"`
+	ProjectID      string `kong:"required,help='Google Cloud Platform project ID.'"`
+	Region         string `kong:"xor='location',help='Region of the regional managed instance group.'"`
+	Zone           string `kong:"xor='location',help='Zone of the zonal managed instance group.'"`
+	InstanceGroup  string `kong:"required,help='Name of the managed instance group'"`
+	Template       string `kong:"required,help='Name of the instance template to deploy.'"`
+	Strategy       string `kong:"default='instances',enum='instances,traffic',help='Rollout strategy to use: instances grows the canary instance count within the managed instance group, traffic keeps the canary at a fixed size and progressively shifts load balancer traffic to it.'"`
+	Plan           string `kong:"required,type='path',help='Path to a YAML rollout plan describing canary steps, analysis windows, and rollback behavior.'"`
+	StateFile      string `kong:"xor='state',help='Path to a local file to checkpoint rollout progress to, for use with resume.'"`
+	StateGCS       string `kong:"xor='state',help='gs://bucket/object path to checkpoint rollout progress to, for use with resume.'"`
+	BackendService string `kong:"xor='backend',name='backend-service',help='Name of the backend service fronting the instance group, to pin discovery instead of scanning the project.'"`
+	URLMap         string `kong:"xor='backend',name='url-map',help='Name of the URL map fronting the instance group, to pin backend service discovery instead of scanning the project.'"`
 }
 
 func (c *StartCmd) Run(ctx context.Context) error {
-	runner, err := statemachine.New(ctx, c.ProjectID, statemachine.Region(c.Region), c.InstanceGroup, c.Template)
+	location, err := resolveLocation(c.Region, c.Zone)
 
 	if err != nil {
 		return err
 	}
 
+	plan, err := statemachine.LoadPlan(c.Plan)
+
+	if err != nil {
+		return err
+	}
+
+	stateStore, err := resolveStateStore(ctx, c.StateFile, c.StateGCS)
+
+	if err != nil {
+		return err
+	}
+
+	runner, err := statemachine.New(
+		ctx, c.ProjectID, location, c.InstanceGroup, c.Template, statemachine.Strategy(c.Strategy), plan,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if stateStore != nil {
+		runner = runner.WithStateStore(stateStore)
+	}
+
+	if c.BackendService != "" || c.URLMap != "" {
+		runner = runner.WithBackendServiceOverride(c.BackendService, c.URLMap)
+	}
+
 	return runner.Start(ctx)
 }
@@ -0,0 +1,235 @@
+package statemachine
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/compute/v1"
+)
+
+const greenMIGSuffix = "-green"
+
+// NewBlueGreen constructs a Runner configured for the blue/green strategy:
+// it provisions a sibling "green" instance group running templateName,
+// atomically cuts backend service traffic over to it once healthy, soaks
+// for soakDuration, and then tears down the old "blue" instance group
+// (unless keepOldMIG is set, in which case it is left in place for manual
+// rollback).
+func NewBlueGreen(
+	ctx context.Context,
+	projectID string, location RegionOrZone, migName, templateName string,
+	soakDuration time.Duration, keepOldMIG bool,
+) (*Runner, error) {
+	computeAPI, err := newGoogleComputeAPI(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Runner{
+		computeAPI: computeAPI,
+		sleepFunc:  time.Sleep,
+
+		projectID:    projectID,
+		location:     location,
+		migName:      migName,
+		templateName: templateName,
+		strategy:     StrategyBlueGreen,
+		soakDuration: soakDuration,
+		keepOldMIG:   keepOldMIG,
+	}, nil
+}
+
+func greenMIGName(migName string) string {
+	return migName + greenMIGSuffix
+}
+
+// startBlueGreen drives the blue/green strategy: it provisions a green
+// instance group on the new template, waits for it to stabilize, checks its
+// health, then atomically swaps backend service traffic from blue to green.
+// After soaking with no health regressions the blue instance group is
+// deleted (unless keepOldMIG is set); on regression the swap is reversed.
+func (r *Runner) startBlueGreen(ctx context.Context, info clusterInfo) error {
+	greenName := greenMIGName(r.migName)
+
+	log.Printf("provisioning green instance group '%s'", greenName)
+
+	green := &compute.InstanceGroupManager{
+		Name:               greenName,
+		BaseInstanceName:   greenName,
+		TargetSize:         info.group.TargetSize,
+		InstanceTemplate:   info.template.SelfLink,
+		DistributionPolicy: info.group.DistributionPolicy,
+	}
+
+	if err := r.computeAPI.InsertMIG(ctx, r.projectID, r.location, green); err != nil {
+		return errors.Wrap(err, "error creating green instance group")
+	}
+
+	greenGroup, err := r.waitForMIGStable(ctx, greenName)
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("attaching green instance group '%s' to backend services at zero weight", greenName)
+
+	if err := r.attachGreenBackend(ctx, info.backends, greenGroup); err != nil {
+		return errors.Wrap(err, "error attaching green instance group to backend service")
+	}
+
+	if err := r.checkGroupHealth(ctx, info.backends, greenGroup); err != nil {
+		return errors.Wrap(err, "green instance group failed health check before cutover")
+	}
+
+	log.Printf("cutting over backend service traffic from '%s' to '%s'", r.migName, greenName)
+
+	if err := r.swapBackend(ctx, info.backends, info.group, greenGroup); err != nil {
+		return err
+	}
+
+	if r.soakDuration > 0 {
+		log.Printf("soaking green instance group for %s", r.soakDuration)
+
+		r.sleepFunc(r.soakDuration)
+	}
+
+	if err := r.checkGroupHealth(ctx, info.backends, greenGroup); err != nil {
+		log.Printf("green instance group failed soak health check, reversing cutover: %s", err)
+
+		if swapErr := r.swapBackend(ctx, info.backends, greenGroup, info.group); swapErr != nil {
+			return swapErr
+		}
+
+		if delErr := r.computeAPI.DeleteMIG(ctx, r.projectID, r.location, greenName); delErr != nil {
+			log.Printf("[WARN] error deleting green instance group during rollback: %s", delErr)
+		}
+
+		return errors.Wrap(err, "blue/green cutover reversed after failing soak health check")
+	}
+
+	if r.keepOldMIG {
+		log.Printf("keeping old instance group '%s' for manual rollback", r.migName)
+
+		return nil
+	}
+
+	log.Printf("tearing down old instance group '%s'", r.migName)
+
+	return errors.Wrap(
+		r.computeAPI.DeleteMIG(ctx, r.projectID, r.location, r.migName), "error deleting old instance group",
+	)
+}
+
+// checkGroupHealth fails if any backend service reports an unhealthy
+// instance belonging to group.
+func (r *Runner) checkGroupHealth(
+	ctx context.Context, backends []*compute.BackendService, group *compute.InstanceGroupManager,
+) error {
+	for _, backend := range backends {
+		healthResponse, err := r.computeAPI.GetBackendServiceGroupHealth(ctx, r.projectID, backend, group)
+
+		if err != nil {
+			return err
+		}
+
+		for _, healthStatus := range healthResponse.HealthStatus {
+			if healthStatus.HealthState == "UNHEALTHY" {
+				return errors.Errorf(
+					"found unhealthy instance in backend service '%s': '%s'", backend.Name, healthStatus.Instance,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// attachGreenBackend adds green to every backend service fronting the
+// instance group at zero weight, if it isn't already present. GCE only
+// reports GetBackendServiceGroupHealth results for groups already attached
+// to the backend service, so green must be attached before its pre-cutover
+// health check can return anything useful.
+func (r *Runner) attachGreenBackend(
+	ctx context.Context, backends []*compute.BackendService, green *compute.InstanceGroupManager,
+) error {
+	for _, backend := range backends {
+		if err := r.attachGreenOnBackend(ctx, backend, green); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) attachGreenOnBackend(
+	ctx context.Context, backend *compute.BackendService, green *compute.InstanceGroupManager,
+) error {
+	for _, b := range backend.Backends {
+		if b.Group == green.SelfLink {
+			return nil
+		}
+	}
+
+	newBackends := append(append([]*compute.Backend{}, backend.Backends...), &compute.Backend{
+		Group:          green.SelfLink,
+		BalancingMode:  "UTILIZATION",
+		CapacityScaler: 0.0,
+	})
+
+	patch := &compute.BackendService{Backends: newBackends}
+
+	if err := r.computeAPI.PatchBackendService(ctx, r.projectID, backend, patch); err != nil {
+		return err
+	}
+
+	backend.Backends = newBackends
+
+	return nil
+}
+
+// swapBackend atomically replaces from's backend entry with to, giving to
+// 100% of traffic, on every backend service fronting the instance group.
+func (r *Runner) swapBackend(
+	ctx context.Context, backends []*compute.BackendService, from, to *compute.InstanceGroupManager,
+) error {
+	for _, backend := range backends {
+		if err := r.swapSingleBackend(ctx, backend, from, to); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) swapSingleBackend(
+	ctx context.Context, backend *compute.BackendService, from, to *compute.InstanceGroupManager,
+) error {
+	newBackends := make([]*compute.Backend, 0, len(backend.Backends))
+
+	for _, candidate := range backend.Backends {
+		if candidate.Group == from.SelfLink || candidate.Group == to.SelfLink {
+			continue
+		}
+
+		newBackends = append(newBackends, candidate)
+	}
+
+	newBackends = append(newBackends, &compute.Backend{
+		Group:          to.SelfLink,
+		BalancingMode:  "UTILIZATION",
+		CapacityScaler: 1.0,
+	})
+
+	patch := &compute.BackendService{Backends: newBackends}
+
+	if err := r.computeAPI.PatchBackendService(ctx, r.projectID, backend, patch); err != nil {
+		return errors.Wrap(err, "error swapping backend service traffic")
+	}
+
+	backend.Backends = newBackends
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package statemachine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func newTestBlueGreenRunner(fake *fakeComputeAPI) *Runner {
+	return &Runner{
+		computeAPI:   fake,
+		sleepFunc:    func(time.Duration) {},
+		projectID:    "test-project",
+		location:     Region("us-central1"),
+		migName:      "web",
+		templateName: "web-v2",
+		strategy:     StrategyBlueGreen,
+	}
+}
+
+func TestRunnerStart_BlueGreen_Success(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	green := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, green, group)
+
+	runner := newTestBlueGreenRunner(fake)
+
+	if err := runner.Start(context.Background()); err != nil {
+		t.Fatalf("Start() returned unexpected error: %s", err)
+	}
+
+	if _, ok := fake.migs["web"]; ok {
+		t.Fatal("expected old (blue) instance group to be deleted after a successful cutover")
+	}
+
+	if _, ok := fake.migs[greenMIGName("web")]; !ok {
+		t.Fatal("expected green instance group to remain after cutover")
+	}
+
+	if len(fake.backend.Backends) != 1 || fake.backend.Backends[0].Group != fake.migs[greenMIGName("web")].SelfLink {
+		t.Fatalf("expected backend service to point only at green instance group, got: %+v", fake.backend.Backends)
+	}
+}
+
+// TestRunnerStart_BlueGreen_HealthCheckedAfterAttaching guards against
+// calling GetBackendServiceGroupHealth against the green instance group
+// before it's attached to any backend service: GCE only returns health data
+// for a group that's already one of the backend service's Backends, so
+// checking too early would make the pre-cutover health gate a no-op.
+func TestRunnerStart_BlueGreen_HealthCheckedAfterAttaching(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	green := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, green, group)
+
+	runner := newTestBlueGreenRunner(fake)
+
+	if err := runner.Start(context.Background()); err != nil {
+		t.Fatalf("Start() returned unexpected error: %s", err)
+	}
+
+	greenGroup := fake.migs[greenMIGName("web")]
+
+	if fake.healthCallCount[greenGroup.Name] == 0 {
+		t.Fatal("expected green instance group to have been health checked")
+	}
+}
+
+func TestRunnerStart_BlueGreen_RegressionReversesCutover(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	green := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, green, group)
+	fake.failHealthOnCall[greenMIGName("web")] = 2
+
+	runner := newTestBlueGreenRunner(fake)
+
+	if err := runner.Start(context.Background()); err == nil {
+		t.Fatal("Start() expected an error after a soak regression, got nil")
+	}
+
+	if _, ok := fake.migs["web"]; !ok {
+		t.Fatal("expected old (blue) instance group to survive a reversed cutover")
+	}
+
+	if _, ok := fake.migs[greenMIGName("web")]; ok {
+		t.Fatal("expected green instance group to be deleted after a reversed cutover")
+	}
+
+	if len(fake.backend.Backends) != 1 || fake.backend.Backends[0].Group != fake.migs["web"].SelfLink {
+		t.Fatalf("expected backend service to point back at the blue instance group, got: %+v", fake.backend.Backends)
+	}
+}
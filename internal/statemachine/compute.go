@@ -2,6 +2,7 @@ package statemachine
 
 import (
 	"context"
+	"regexp"
 
 	"github.com/pkg/errors"
 	"google.golang.org/api/compute/v1"
@@ -33,15 +34,29 @@ type computeAPI interface {
 		ctx context.Context, projectID string, location RegionOrZone, name string, patch *compute.InstanceGroupManager,
 	) error
 
+	InsertMIG(
+		ctx context.Context, projectID string, location RegionOrZone, mig *compute.InstanceGroupManager,
+	) error
+
+	DeleteMIG(ctx context.Context, projectID string, location RegionOrZone, name string) error
+
 	GetInstanceTemplate(ctx context.Context, projectID, name string) (*compute.InstanceTemplate, error)
 
-	FindBackendServiceWithMIG(
+	FindBackendServicesWithMIG(
 		ctx context.Context, projectID string, mig *compute.InstanceGroupManager,
-	) (*compute.BackendService, error)
+	) ([]*compute.BackendService, error)
+
+	GetBackendService(ctx context.Context, projectID, name string) (*compute.BackendService, error)
+
+	GetURLMapDefaultBackendService(ctx context.Context, projectID, urlMap string) (*compute.BackendService, error)
 
 	GetBackendServiceGroupHealth(
 		ctx context.Context, projectID string, backend *compute.BackendService, mig *compute.InstanceGroupManager,
 	) (*compute.BackendServiceGroupHealth, error)
+
+	PatchBackendService(
+		ctx context.Context, projectID string, backend *compute.BackendService, patch *compute.BackendService,
+	) error
 }
 
 var _ computeAPI = (*googleComputeAPI)(nil)
@@ -165,6 +180,62 @@ func (g *googleComputeAPI) PatchMIG(
 	return nil
 }
 
+func (g *googleComputeAPI) InsertMIG(
+	ctx context.Context,
+	projectID string, location RegionOrZone, mig *compute.InstanceGroupManager,
+) error {
+	var err error
+
+	switch {
+	case location.Region != "":
+		_, err = g.computeService.
+			RegionInstanceGroupManagers.
+			Insert(projectID, location.Region, mig).
+			Context(ctx).
+			Do()
+
+	case location.Zone != "":
+		_, err = g.computeService.
+			InstanceGroupManagers.
+			Insert(projectID, location.Zone, mig).
+			Context(ctx).
+			Do()
+
+	default:
+		err = errors.New("must specify either region or zone")
+	}
+
+	return errors.Wrap(err, "error creating instance group")
+}
+
+func (g *googleComputeAPI) DeleteMIG(
+	ctx context.Context,
+	projectID string, location RegionOrZone, name string,
+) error {
+	var err error
+
+	switch {
+	case location.Region != "":
+		_, err = g.computeService.
+			RegionInstanceGroupManagers.
+			Delete(projectID, location.Region, name).
+			Context(ctx).
+			Do()
+
+	case location.Zone != "":
+		_, err = g.computeService.
+			InstanceGroupManagers.
+			Delete(projectID, location.Zone, name).
+			Context(ctx).
+			Do()
+
+	default:
+		err = errors.New("must specify either region or zone")
+	}
+
+	return errors.Wrap(err, "error deleting instance group")
+}
+
 func (g *googleComputeAPI) GetInstanceTemplate(
 	ctx context.Context,
 	projectID, name string,
@@ -178,11 +249,15 @@ func (g *googleComputeAPI) GetInstanceTemplate(
 	return template, errors.Wrap(err, "error retrieving instance template information")
 }
 
-func (g googleComputeAPI) FindBackendServiceWithMIG(
+// FindBackendServicesWithMIG scans every backend service in the project and
+// returns all of those whose Backends reference mig, since a single MIG can
+// sit behind multiple backend services (e.g. path-based routing or multi-region load
+// balancers fronted by a URL map).
+func (g googleComputeAPI) FindBackendServicesWithMIG(
 	ctx context.Context,
 	projectID string, mig *compute.InstanceGroupManager,
-) (*compute.BackendService, error) {
-	var backendService *compute.BackendService
+) ([]*compute.BackendService, error) {
+	var backendServices []*compute.BackendService
 
 	err := g.computeService.
 		BackendServices.
@@ -193,11 +268,11 @@ func (g googleComputeAPI) FindBackendServiceWithMIG(
 				// check the list of backend services
 				for _, candidateBackendService := range list.BackendServices {
 					// ...by iterating over the backends in the backend service
-					for _, backend := range backendService.Backends {
+					for _, backend := range candidateBackendService.Backends {
 						if backend.Group == mig.SelfLink {
-							backendService = candidateBackendService
+							backendServices = append(backendServices, candidateBackendService)
 
-							return nil
+							break
 						}
 					}
 				}
@@ -210,11 +285,48 @@ func (g googleComputeAPI) FindBackendServiceWithMIG(
 		return nil, errors.Wrap(err, "error listing backend services in project")
 	}
 
-	if backendService == nil {
-		return nil, errors.New("could not find backend service containing the specified instance group")
+	if len(backendServices) == 0 {
+		return nil, errors.New("could not find any backend service containing the specified instance group")
+	}
+
+	return backendServices, nil
+}
+
+func (g googleComputeAPI) GetBackendService(
+	ctx context.Context,
+	projectID, name string,
+) (*compute.BackendService, error) {
+	backendService, err := g.computeService.BackendServices.Get(projectID, name).Context(ctx).Do()
+
+	return backendService, errors.Wrap(err, "error retrieving backend service")
+}
+
+// backendServiceSelfLinkPattern matches the backend service name out of a
+// GCE backend service self link, e.g.
+// https://www.googleapis.com/compute/v1/projects/my-project/global/backendServices/my-backend
+var backendServiceSelfLinkPattern = regexp.MustCompile(`/backendServices/([^/]+)$`)
+
+// GetURLMapDefaultBackendService resolves the backend service a URL map
+// sends unmatched traffic to, so operators fronting a MIG with path-based
+// routing can pin discovery to the URL map instead of an aggregated-list
+// scan.
+func (g googleComputeAPI) GetURLMapDefaultBackendService(
+	ctx context.Context,
+	projectID, urlMap string,
+) (*compute.BackendService, error) {
+	m, err := g.computeService.UrlMaps.Get(projectID, urlMap).Context(ctx).Do()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving URL map")
+	}
+
+	match := backendServiceSelfLinkPattern.FindStringSubmatch(m.DefaultService)
+
+	if match == nil {
+		return nil, errors.Errorf("could not parse backend service from URL map default service '%s'", m.DefaultService)
 	}
 
-	return backendService, nil
+	return g.GetBackendService(ctx, projectID, match[1])
 }
 
 func (g googleComputeAPI) GetBackendServiceGroupHealth(
@@ -248,3 +360,28 @@ func (g googleComputeAPI) GetBackendServiceGroupHealth(
 
 	return groupHealth, nil
 }
+
+func (g *googleComputeAPI) PatchBackendService(
+	ctx context.Context,
+	projectID string, backend *compute.BackendService, patch *compute.BackendService,
+) error {
+	var err error
+
+	switch {
+	case backend.Region != "":
+		_, err = g.computeService.
+			RegionBackendServices.
+			Patch(projectID, backend.Region, backend.Name, patch).
+			Context(ctx).
+			Do()
+
+	default:
+		_, err = g.computeService.
+			BackendServices.
+			Patch(projectID, backend.Name, patch).
+			Context(ctx).
+			Do()
+	}
+
+	return errors.Wrap(err, "error patching backend service")
+}
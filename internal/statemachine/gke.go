@@ -0,0 +1,100 @@
+package statemachine
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/container/v1"
+)
+
+type containerAPI interface {
+	GetNodePool(ctx context.Context, projectID, location, cluster, nodePool string) (*container.NodePool, error)
+}
+
+var _ containerAPI = (*googleContainerAPI)(nil)
+
+type googleContainerAPI struct {
+	containerService *container.Service
+}
+
+func newGoogleContainerAPI(ctx context.Context) (*googleContainerAPI, error) {
+	containerService, err := container.NewService(ctx)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error initializing Google Kubernetes Engine API client")
+	}
+
+	return &googleContainerAPI{containerService}, nil
+}
+
+func (g *googleContainerAPI) GetNodePool(
+	ctx context.Context,
+	projectID, location, cluster, nodePool string,
+) (*container.NodePool, error) {
+	name := fmt.Sprintf("projects/%s/locations/%s/clusters/%s/nodePools/%s", projectID, location, cluster, nodePool)
+
+	pool, err := g.containerService.Projects.Locations.Clusters.NodePools.Get(name).Context(ctx).Do()
+
+	return pool, errors.Wrap(err, "error retrieving node pool information")
+}
+
+// instanceGroupManagerSelfLinkPattern matches a GCE instance group manager
+// self link, e.g.
+// https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-a/instanceGroupManagers/gke-cluster-pool-abcd1234-grp
+var instanceGroupManagerSelfLinkPattern = regexp.MustCompile(
+	`/projects/[^/]+/zones/(?P<zone>[^/]+)/instanceGroupManagers/(?P<name>[^/]+)$`,
+)
+
+// nodePoolInstanceGroupManager extracts the zone and name of a zonal
+// managed instance group backing a GKE node pool from one of its
+// instanceGroupUrls self links. GKE creates one instance group manager per
+// zone for a node pool, and only the first is used here; the instance
+// groups in a regional (multi-zone) node pool's other zones are not rolled
+// out.
+func nodePoolInstanceGroupManager(nodePool *container.NodePool) (zone, name string, err error) {
+	if len(nodePool.InstanceGroupUrls) == 0 {
+		return "", "", errors.New("node pool has no instance group URLs")
+	}
+
+	selfLink := nodePool.InstanceGroupUrls[0]
+	match := instanceGroupManagerSelfLinkPattern.FindStringSubmatch(selfLink)
+
+	if match == nil {
+		return "", "", errors.Errorf("could not parse instance group manager self link: '%s'", selfLink)
+	}
+
+	zoneIndex := instanceGroupManagerSelfLinkPattern.SubexpIndex("zone")
+	nameIndex := instanceGroupManagerSelfLinkPattern.SubexpIndex("name")
+
+	return match[zoneIndex], match[nameIndex], nil
+}
+
+// NewFromNodePool resolves the managed instance group backing a GKE node
+// pool and constructs a Runner for it, reusing the same canary state
+// machine used for standalone managed instance groups.
+func NewFromNodePool(
+	ctx context.Context,
+	projectID, cluster, location, nodePool, templateName string, strategy Strategy, plan *Plan,
+) (*Runner, error) {
+	containerAPI, err := newGoogleContainerAPI(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := containerAPI.GetNodePool(ctx, projectID, location, cluster, nodePool)
+
+	if err != nil {
+		return nil, err
+	}
+
+	zone, migName, err := nodePoolInstanceGroupManager(pool)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return New(ctx, projectID, Zone(zone), migName, templateName, strategy, plan)
+}
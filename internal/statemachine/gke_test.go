@@ -0,0 +1,35 @@
+package statemachine
+
+import (
+	"testing"
+
+	"google.golang.org/api/container/v1"
+)
+
+func TestNodePoolInstanceGroupManager(t *testing.T) {
+	pool := &container.NodePool{
+		InstanceGroupUrls: []string{
+			"https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-a/instanceGroupManagers/gke-cluster-pool-abcd1234-grp",
+		},
+	}
+
+	zone, name, err := nodePoolInstanceGroupManager(pool)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if zone != "us-central1-a" {
+		t.Errorf("expected zone 'us-central1-a', got '%s'", zone)
+	}
+
+	if name != "gke-cluster-pool-abcd1234-grp" {
+		t.Errorf("expected name 'gke-cluster-pool-abcd1234-grp', got '%s'", name)
+	}
+}
+
+func TestNodePoolInstanceGroupManager_NoInstanceGroups(t *testing.T) {
+	if _, _, err := nodePoolInstanceGroupManager(&container.NodePool{}); err == nil {
+		t.Fatal("expected an error for a node pool with no instance group URLs")
+	}
+}
@@ -0,0 +1,298 @@
+package statemachine
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/compute/v1"
+	"gopkg.in/yaml.v3"
+)
+
+// Plan is a declarative rollout plan loaded from YAML via --plan. It
+// replaces bulldozer's built-in canary-doubling and single-instance health
+// check with an operator-specified sequence of steps, each followed by an
+// analysis window, plus thresholds that control automatic rollback.
+type Plan struct {
+	Steps               []PlanStep `yaml:"steps"`
+	MaxUnhealthyPercent float64    `yaml:"maxUnhealthyPercent"`
+	FailureThreshold    int        `yaml:"failureThreshold"`
+	Rollback            bool       `yaml:"rollback"`
+}
+
+// PlanStep describes a single step of the rollout: a canary instance count
+// for the instances strategy, or a traffic weight percentage for the
+// traffic strategy, followed by a pause and an analysis window.
+type PlanStep struct {
+	CanarySize    int64          `yaml:"canarySize"`
+	TrafficWeight int64          `yaml:"trafficWeight"`
+	Pause         time.Duration  `yaml:"pause"`
+	Analysis      AnalysisWindow `yaml:"analysis"`
+}
+
+// AnalysisWindow samples canary health every Interval for Duration after a
+// step has been applied and the instance group has stabilized.
+type AnalysisWindow struct {
+	Interval time.Duration `yaml:"interval"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// LoadPlan reads and validates a rollout plan from the YAML file at path.
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading rollout plan")
+	}
+
+	var plan Plan
+
+	if err := yaml.Unmarshal(data, &plan); err != nil {
+		return nil, errors.Wrap(err, "error parsing rollout plan")
+	}
+
+	if len(plan.Steps) == 0 {
+		return nil, errors.New("rollout plan must specify at least one step")
+	}
+
+	if plan.FailureThreshold <= 0 {
+		plan.FailureThreshold = 1
+	}
+
+	return &plan, nil
+}
+
+// runPlan drives the rollout according to the configured plan: at each step
+// it applies the canary size or traffic weight, waits for the instance
+// group to stabilize, then analyzes canary health for the step's analysis
+// window before advancing. If analysis fails and the plan allows it, the
+// rollout is automatically rolled back before returning an error. A step
+// that reaches full canary size or traffic weight is only promoted and torn
+// down after its own analysis window has passed, so a failing final step is
+// caught before bulldozer commits to it.
+func (r *Runner) runPlan(ctx context.Context, info clusterInfo) error {
+	for i, step := range r.plan.Steps {
+		if i < r.resumeStepIndex {
+			continue
+		}
+
+		log.Printf("applying rollout plan step %d/%d", i+1, len(r.plan.Steps))
+
+		appliedInfo, reachedFull, err := r.applyStep(ctx, info, step)
+
+		if err != nil {
+			return err
+		}
+
+		appliedInfo, err = r.waitUntilStable(ctx, appliedInfo)
+
+		if err != nil {
+			return err
+		}
+
+		if step.Pause > 0 {
+			r.sleepFunc(step.Pause)
+		}
+
+		if err := r.analyzeStep(ctx, appliedInfo, step.Analysis); err != nil {
+			if !r.plan.Rollback {
+				return err
+			}
+
+			log.Printf("step %d/%d failed analysis, rolling back: %s", i+1, len(r.plan.Steps), err)
+
+			if rollbackErr := r.rollback(ctx, appliedInfo); rollbackErr != nil {
+				return errors.Wrap(rollbackErr, "error rolling back after failing analysis")
+			}
+
+			return errors.Wrap(err, "rollout rolled back after failing analysis")
+		}
+
+		if reachedFull {
+			appliedInfo, err = r.finalizeStep(ctx, appliedInfo)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		info = appliedInfo
+
+		if err := r.checkpoint(ctx, info, i); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("rollout complete")
+
+	return nil
+}
+
+// applyStep applies step's canary size or traffic weight and reports
+// whether it reaches full promotion (canarySize >= the group's target size,
+// or trafficWeight >= 100). Promotion itself is deferred to finalizeStep,
+// which the caller must only invoke once analysis for this step has passed.
+func (r *Runner) applyStep(ctx context.Context, info clusterInfo, step PlanStep) (clusterInfo, bool, error) {
+	if r.strategy == StrategyTraffic {
+		appliedInfo, err := r.applyTrafficStep(ctx, info, step)
+
+		return appliedInfo, step.TrafficWeight >= 100, err
+	}
+
+	appliedInfo, err := r.applyInstancesStep(ctx, info, step)
+
+	return appliedInfo, step.CanarySize >= info.group.TargetSize, err
+}
+
+// finalizeStep promotes the canary template to primary and tears down any
+// temporary canary resources, once a step that reached full canary size or
+// traffic weight has passed analysis.
+func (r *Runner) finalizeStep(ctx context.Context, info clusterInfo) (clusterInfo, error) {
+	if r.strategy == StrategyTraffic {
+		return r.finalizeTrafficStep(ctx, info)
+	}
+
+	return r.finalizeInstancesStep(ctx, info)
+}
+
+// rollback reverses an in-progress rollout: for the instances strategy the
+// primary instance group is patched back to 100% of its original template,
+// and for the traffic strategy the canary instance group is drained and
+// deleted.
+func (r *Runner) rollback(ctx context.Context, info clusterInfo) error {
+	if r.strategy == StrategyTraffic {
+		return r.rollbackTraffic(ctx, info)
+	}
+
+	primaryTemplate, _, err := r.findCanaryState(info)
+
+	if err != nil {
+		return err
+	}
+
+	patch := &compute.InstanceGroupManager{
+		Versions: []*compute.InstanceGroupManagerVersion{
+			{InstanceTemplate: primaryTemplate},
+		},
+	}
+
+	err = r.computeAPI.PatchMIG(ctx, r.projectID, r.location, r.migName, patch)
+
+	return errors.Wrap(err, "error rolling back instance group to primary template")
+}
+
+// analyzeStep samples canary health every window.Interval for
+// window.Duration, failing once plan.FailureThreshold consecutive samples
+// exceed plan.MaxUnhealthyPercent. A zero-value window still takes a single
+// sample.
+func (r *Runner) analyzeStep(ctx context.Context, info clusterInfo, window AnalysisWindow) error {
+	if window.Duration <= 0 || window.Interval <= 0 {
+		return r.sampleCanaryHealth(ctx, info)
+	}
+
+	log.Printf("analyzing canary health for %s", window.Duration)
+
+	samples := int(window.Duration / window.Interval)
+
+	if samples < 1 {
+		samples = 1
+	}
+
+	consecutiveFailures := 0
+
+	for sample := 0; sample < samples; sample++ {
+		if err := r.sampleCanaryHealth(ctx, info); err != nil {
+			consecutiveFailures++
+
+			log.Printf("analysis sample failed (%d/%d consecutive): %s", consecutiveFailures, r.plan.FailureThreshold, err)
+
+			if consecutiveFailures >= r.plan.FailureThreshold {
+				return err
+			}
+		} else {
+			consecutiveFailures = 0
+		}
+
+		if sample < samples-1 {
+			r.sleepFunc(window.Interval)
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) sampleCanaryHealth(ctx context.Context, info clusterInfo) error {
+	percent, err := r.canaryUnhealthyPercent(ctx, info)
+
+	if err != nil {
+		return err
+	}
+
+	if percent > r.plan.MaxUnhealthyPercent {
+		return errors.Errorf(
+			"canary unhealthy percentage %.2f exceeds threshold %.2f", percent, r.plan.MaxUnhealthyPercent,
+		)
+	}
+
+	return nil
+}
+
+// canaryUnhealthyPercent returns the percentage of canary instances that any
+// backend service reports as unhealthy: instances on the canary template
+// for the instances strategy, or all instances of the canary instance group
+// for the traffic strategy.
+func (r *Runner) canaryUnhealthyPercent(ctx context.Context, info clusterInfo) (float64, error) {
+	targetGroup := info.group
+
+	if r.strategy == StrategyTraffic {
+		if info.canary == nil {
+			return 0, nil
+		}
+
+		targetGroup = info.canary
+	}
+
+	isUnhealthy := make(map[string]bool)
+
+	for _, backend := range info.backends {
+		healthResponse, err := r.computeAPI.GetBackendServiceGroupHealth(ctx, r.projectID, backend, targetGroup)
+
+		if err != nil {
+			return 0, err
+		}
+
+		for _, healthStatus := range healthResponse.HealthStatus {
+			if healthStatus.HealthState == "UNHEALTHY" {
+				isUnhealthy[healthStatus.Instance] = true
+			}
+		}
+	}
+
+	instances, err := r.computeAPI.GetMIGInstances(ctx, r.projectID, r.location, targetGroup.Name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var total, unhealthy int
+
+	for _, instance := range instances {
+		if r.strategy != StrategyTraffic && instance.Version.InstanceTemplate != info.template.SelfLink {
+			continue
+		}
+
+		total++
+
+		if isUnhealthy[instance.Instance] {
+			unhealthy++
+		}
+	}
+
+	if total == 0 {
+		return 0, nil
+	}
+
+	return float64(unhealthy) / float64(total) * 100, nil
+}
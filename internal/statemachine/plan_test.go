@@ -0,0 +1,782 @@
+package statemachine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/compute/v1"
+)
+
+// fakeComputeAPI is an in-memory computeAPI used to drive Runner.Start
+// through the plan-driven rollout without talking to GCE.
+type fakeComputeAPI struct {
+	migs         map[string]*compute.InstanceGroupManager
+	migInstances map[string][]*compute.ManagedInstance
+	template     *compute.InstanceTemplate
+
+	// backend is the first of backends, kept for convenience in tests that
+	// only care about a single backend service.
+	backend *compute.BackendService
+
+	// backends holds every backend service FindBackendServicesWithMIG
+	// reports as fronting the instance group.
+	backends []*compute.BackendService
+
+	// unhealthyTemplate marks every instance running this template self
+	// link as unhealthy in GetBackendServiceGroupHealth.
+	unhealthyTemplate string
+
+	// failHealthOnCall marks every instance in the named instance group as
+	// unhealthy on the given (1-based) call to GetBackendServiceGroupHealth
+	// for that group, letting tests simulate a later regression.
+	failHealthOnCall map[string]int
+	healthCallCount  map[string]int
+}
+
+func newFakeComputeAPI(primary, canary *compute.InstanceTemplate, group *compute.InstanceGroupManager) *fakeComputeAPI {
+	fake := &fakeComputeAPI{
+		migs:             map[string]*compute.InstanceGroupManager{group.Name: group},
+		migInstances:     map[string][]*compute.ManagedInstance{},
+		template:         canary,
+		failHealthOnCall: map[string]int{},
+		healthCallCount:  map[string]int{},
+	}
+
+	fake.backend = &compute.BackendService{
+		Name: "fake-backend",
+		Backends: []*compute.Backend{
+			{Group: group.SelfLink, BalancingMode: "UTILIZATION", CapacityScaler: 1.0},
+		},
+	}
+	fake.backends = []*compute.BackendService{fake.backend}
+
+	fake.regenerateInstances(group.Name)
+
+	return fake
+}
+
+func (f *fakeComputeAPI) regenerateInstances(name string) {
+	group := f.migs[name]
+
+	var canaryFixed int64
+	var instances []*compute.ManagedInstance
+
+	for vi, version := range group.Versions {
+		if version.TargetSize != nil {
+			canaryFixed += version.TargetSize.Fixed
+		}
+
+		size := version.TargetSize
+		fixed := group.TargetSize - canaryFixed
+
+		if size != nil {
+			fixed = size.Fixed
+		}
+
+		for i := int64(0); i < fixed; i++ {
+			instances = append(instances, &compute.ManagedInstance{
+				Instance: fmt.Sprintf("%s-v%d-i%d", name, vi, i),
+				Version:  &compute.ManagedInstanceVersion{InstanceTemplate: version.InstanceTemplate},
+			})
+		}
+	}
+
+	if len(group.Versions) == 0 {
+		for i := int64(0); i < group.TargetSize; i++ {
+			instances = append(instances, &compute.ManagedInstance{
+				Instance: fmt.Sprintf("%s-i%d", name, i),
+				Version:  &compute.ManagedInstanceVersion{InstanceTemplate: group.InstanceTemplate},
+			})
+		}
+	}
+
+	f.migInstances[name] = instances
+}
+
+func (f *fakeComputeAPI) GetMIG(
+	ctx context.Context, projectID string, location RegionOrZone, name string,
+) (*compute.InstanceGroupManager, error) {
+	group, ok := f.migs[name]
+
+	if !ok {
+		return nil, fmt.Errorf("no such instance group: %s", name)
+	}
+
+	group.Status = &compute.InstanceGroupManagerStatus{
+		IsStable:      true,
+		VersionTarget: &compute.InstanceGroupManagerStatusVersionTarget{IsReached: true},
+	}
+
+	return group, nil
+}
+
+func (f *fakeComputeAPI) GetMIGInstances(
+	ctx context.Context, projectID string, location RegionOrZone, name string,
+) ([]*compute.ManagedInstance, error) {
+	return f.migInstances[name], nil
+}
+
+func (f *fakeComputeAPI) PatchMIG(
+	ctx context.Context, projectID string, location RegionOrZone, name string, patch *compute.InstanceGroupManager,
+) error {
+	group, ok := f.migs[name]
+
+	if !ok {
+		return fmt.Errorf("no such instance group: %s", name)
+	}
+
+	group.Versions = patch.Versions
+
+	f.regenerateInstances(name)
+
+	return nil
+}
+
+func (f *fakeComputeAPI) InsertMIG(
+	ctx context.Context, projectID string, location RegionOrZone, mig *compute.InstanceGroupManager,
+) error {
+	mig.SelfLink = "https://compute.googleapis.com/fake/" + mig.Name
+
+	f.migs[mig.Name] = mig
+
+	f.regenerateInstances(mig.Name)
+
+	return nil
+}
+
+func (f *fakeComputeAPI) DeleteMIG(ctx context.Context, projectID string, location RegionOrZone, name string) error {
+	delete(f.migs, name)
+	delete(f.migInstances, name)
+
+	return nil
+}
+
+func (f *fakeComputeAPI) GetInstanceTemplate(ctx context.Context, projectID, name string) (*compute.InstanceTemplate, error) {
+	return f.template, nil
+}
+
+func (f *fakeComputeAPI) FindBackendServicesWithMIG(
+	ctx context.Context, projectID string, mig *compute.InstanceGroupManager,
+) ([]*compute.BackendService, error) {
+	return f.backends, nil
+}
+
+func (f *fakeComputeAPI) GetBackendService(ctx context.Context, projectID, name string) (*compute.BackendService, error) {
+	return f.backend, nil
+}
+
+func (f *fakeComputeAPI) GetURLMapDefaultBackendService(
+	ctx context.Context, projectID, urlMap string,
+) (*compute.BackendService, error) {
+	return f.backend, nil
+}
+
+func (f *fakeComputeAPI) GetBackendServiceGroupHealth(
+	ctx context.Context, projectID string, backend *compute.BackendService, mig *compute.InstanceGroupManager,
+) (*compute.BackendServiceGroupHealth, error) {
+	attached := false
+
+	for _, b := range backend.Backends {
+		if b.Group == mig.SelfLink {
+			attached = true
+
+			break
+		}
+	}
+
+	if !attached {
+		return nil, fmt.Errorf(
+			"invalid value for field 'resource.group': '%s' is not a backend of backend service '%s'",
+			mig.SelfLink, backend.Name,
+		)
+	}
+
+	f.healthCallCount[mig.Name]++
+
+	failThisCall := f.failHealthOnCall[mig.Name] != 0 && f.healthCallCount[mig.Name] == f.failHealthOnCall[mig.Name]
+
+	response := &compute.BackendServiceGroupHealth{}
+
+	for _, instance := range f.migInstances[mig.Name] {
+		state := "HEALTHY"
+
+		if failThisCall || (f.unhealthyTemplate != "" && instance.Version.InstanceTemplate == f.unhealthyTemplate) {
+			state = "UNHEALTHY"
+		}
+
+		response.HealthStatus = append(response.HealthStatus, &compute.HealthStatus{
+			Instance:    instance.Instance,
+			HealthState: state,
+		})
+	}
+
+	return response, nil
+}
+
+func (f *fakeComputeAPI) PatchBackendService(
+	ctx context.Context, projectID string, backend *compute.BackendService, patch *compute.BackendService,
+) error {
+	backend.Backends = patch.Backends
+
+	return nil
+}
+
+var _ computeAPI = (*fakeComputeAPI)(nil)
+
+func newTestRunner(fake *fakeComputeAPI, strategy Strategy, plan *Plan) *Runner {
+	return &Runner{
+		computeAPI:   fake,
+		sleepFunc:    func(time.Duration) {},
+		projectID:    "test-project",
+		location:     Region("us-central1"),
+		migName:      "web",
+		templateName: "web-v2",
+		strategy:     strategy,
+		plan:         plan,
+		attempt:      1,
+	}
+}
+
+// fakeStateStore is an in-memory StateStore used to assert on the
+// checkpoints a Runner writes during a rollout.
+type fakeStateStore struct {
+	saved []*State
+}
+
+func (f *fakeStateStore) Save(ctx context.Context, state *State) error {
+	saved := *state
+
+	f.saved = append(f.saved, &saved)
+
+	return nil
+}
+
+func (f *fakeStateStore) Load(ctx context.Context) (*State, error) {
+	if len(f.saved) == 0 {
+		return nil, errors.New("no checkpoint saved")
+	}
+
+	return f.saved[len(f.saved)-1], nil
+}
+
+func TestRunnerStart_InstancesStrategy_Success(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{CanarySize: 1},
+			{CanarySize: 4},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+		Rollback:            true,
+	}
+
+	runner := newTestRunner(fake, StrategyInstances, plan)
+
+	if err := runner.Start(context.Background()); err != nil {
+		t.Fatalf("Start() returned unexpected error: %s", err)
+	}
+
+	final := fake.migs["web"]
+
+	if len(final.Versions) != 1 || final.Versions[0].InstanceTemplate != canary.SelfLink {
+		t.Fatalf("expected instance group to be fully promoted to canary template, got versions: %+v", final.Versions)
+	}
+}
+
+func TestRunnerStart_InstancesStrategy_Rollback(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+	fake.unhealthyTemplate = canary.SelfLink
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{CanarySize: 1},
+			{CanarySize: 4},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+		Rollback:            true,
+	}
+
+	runner := newTestRunner(fake, StrategyInstances, plan)
+
+	if err := runner.Start(context.Background()); err == nil {
+		t.Fatal("Start() expected an error after a failed analysis, got nil")
+	}
+
+	final := fake.migs["web"]
+
+	if len(final.Versions) != 1 || final.Versions[0].InstanceTemplate != primary.SelfLink {
+		t.Fatalf("expected instance group to be rolled back to primary template, got versions: %+v", final.Versions)
+	}
+}
+
+func TestRunnerStart_TrafficStrategy_Success(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{TrafficWeight: 10},
+			{TrafficWeight: 100},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+		Rollback:            true,
+	}
+
+	runner := newTestRunner(fake, StrategyTraffic, plan)
+
+	if err := runner.Start(context.Background()); err != nil {
+		t.Fatalf("Start() returned unexpected error: %s", err)
+	}
+
+	if _, ok := fake.migs[canaryMIGName("web")]; ok {
+		t.Fatal("expected canary instance group to be torn down after promotion")
+	}
+
+	final := fake.migs["web"]
+
+	if len(final.Versions) != 1 || final.Versions[0].InstanceTemplate != canary.SelfLink {
+		t.Fatalf("expected instance group to be promoted to canary template, got versions: %+v", final.Versions)
+	}
+}
+
+func TestRunnerStart_TrafficStrategy_MultipleBackendServices(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+
+	secondBackend := &compute.BackendService{
+		Name: "fake-backend-2",
+		Backends: []*compute.Backend{
+			{Group: group.SelfLink, BalancingMode: "UTILIZATION", CapacityScaler: 1.0},
+		},
+	}
+	fake.backends = append(fake.backends, secondBackend)
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{TrafficWeight: 10},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+	}
+
+	runner := newTestRunner(fake, StrategyTraffic, plan)
+
+	if err := runner.Start(context.Background()); err != nil {
+		t.Fatalf("Start() returned unexpected error: %s", err)
+	}
+
+	canaryGroup := fake.migs[canaryMIGName("web")]
+
+	for _, backendService := range fake.backends {
+		var canaryWeight float64
+
+		for _, b := range backendService.Backends {
+			if b.Group == canaryGroup.SelfLink {
+				canaryWeight = b.CapacityScaler
+			}
+		}
+
+		if canaryWeight != 0.1 {
+			t.Errorf(
+				"expected backend service '%s' to shift 10%% of traffic to the canary, got scaler %.2f",
+				backendService.Name, canaryWeight,
+			)
+		}
+	}
+}
+
+func TestRunnerStart_TrafficStrategy_Rollback(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+	fake.unhealthyTemplate = canary.SelfLink
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{TrafficWeight: 10},
+			{TrafficWeight: 100},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+		Rollback:            true,
+	}
+
+	runner := newTestRunner(fake, StrategyTraffic, plan)
+
+	if err := runner.Start(context.Background()); err == nil {
+		t.Fatal("Start() expected an error after a failed analysis, got nil")
+	}
+
+	if _, ok := fake.migs[canaryMIGName("web")]; ok {
+		t.Fatal("expected canary instance group to be deleted during rollback")
+	}
+
+	final := fake.migs["web"]
+
+	if len(final.Versions) != 1 || final.Versions[0].InstanceTemplate != primary.SelfLink {
+		t.Fatalf("expected primary instance group to be untouched, got versions: %+v", final.Versions)
+	}
+}
+
+func TestRunnerStart_ChecksPointsProgressAfterEachStep(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{CanarySize: 1},
+			{CanarySize: 4},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+	}
+
+	store := &fakeStateStore{}
+	runner := newTestRunner(fake, StrategyInstances, plan).WithStateStore(store)
+
+	if err := runner.Start(context.Background()); err != nil {
+		t.Fatalf("Start() returned unexpected error: %s", err)
+	}
+
+	if len(store.saved) != len(plan.Steps) {
+		t.Fatalf("expected %d checkpoints, got %d", len(plan.Steps), len(store.saved))
+	}
+
+	last := store.saved[len(store.saved)-1]
+
+	if last.StepIndex != len(plan.Steps) {
+		t.Errorf("expected final checkpoint step index %d, got %d", len(plan.Steps), last.StepIndex)
+	}
+
+	if store.saved[0].PrimaryTemplate != primary.SelfLink {
+		t.Errorf("expected first checkpoint primary template '%s', got '%s'", primary.SelfLink, store.saved[0].PrimaryTemplate)
+	}
+}
+
+func TestRunnerStart_ResumeSkipsCompletedSteps(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions: []*compute.InstanceGroupManagerVersion{
+			{InstanceTemplate: primary.SelfLink},
+			{Name: "canary", InstanceTemplate: canary.SelfLink, TargetSize: &compute.FixedOrPercent{Fixed: 1}},
+		},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{CanarySize: 1},
+			{CanarySize: 4},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+	}
+
+	runner := newTestRunner(fake, StrategyInstances, plan).ResumeFrom(1, 3)
+
+	if err := runner.Start(context.Background()); err != nil {
+		t.Fatalf("Start() returned unexpected error: %s", err)
+	}
+
+	if runner.attempt != 4 {
+		t.Errorf("expected attempt counter to advance to 4, got %d", runner.attempt)
+	}
+
+	final := fake.migs["web"]
+
+	if len(final.Versions) != 1 || final.Versions[0].InstanceTemplate != canary.SelfLink {
+		t.Fatalf("expected instance group to be fully promoted to canary template, got versions: %+v", final.Versions)
+	}
+}
+
+func TestRunnerStart_InstancesStrategy_ZonalMIG(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:             "web",
+		SelfLink:         "mig/web",
+		TargetSize:       4,
+		InstanceTemplate: primary.SelfLink,
+		Versions:         []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{CanarySize: 1},
+			{CanarySize: 4},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+	}
+
+	runner := newTestRunner(fake, StrategyInstances, plan)
+	runner.location = Zone("us-central1-a")
+
+	if err := runner.Start(context.Background()); err != nil {
+		t.Fatalf("Start() returned unexpected error on a zonal instance group: %s", err)
+	}
+
+	final := fake.migs["web"]
+
+	if len(final.Versions) != 1 || final.Versions[0].InstanceTemplate != canary.SelfLink {
+		t.Fatalf("expected instance group to be fully promoted to canary template, got versions: %+v", final.Versions)
+	}
+}
+
+// TestRunnerStart_InstancesStrategy_FinalStepFailsAnalysis exercises a
+// single-step plan whose only step reaches the group's target size (i.e. it
+// is also the promoting step). Analysis must run against the pre-promotion
+// state so that a failing final step is caught and rolled back instead of
+// silently promoting first and analyzing a group findCanaryState can no
+// longer make sense of.
+func TestRunnerStart_InstancesStrategy_FinalStepFailsAnalysis(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+	fake.unhealthyTemplate = canary.SelfLink
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{CanarySize: 4},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+		Rollback:            true,
+	}
+
+	runner := newTestRunner(fake, StrategyInstances, plan)
+
+	if err := runner.Start(context.Background()); err == nil {
+		t.Fatal("Start() expected an error after the final step failed analysis, got nil")
+	}
+
+	final := fake.migs["web"]
+
+	if len(final.Versions) != 1 || final.Versions[0].InstanceTemplate != primary.SelfLink {
+		t.Fatalf("expected instance group to be rolled back to primary template, got versions: %+v", final.Versions)
+	}
+}
+
+// TestRunnerStart_TrafficStrategy_FinalStepFailsAnalysis covers the same
+// final-step scenario for the traffic strategy: reaching 100% traffic weight
+// must not promote the primary instance group before analysis runs, since
+// canaryUnhealthyPercent reports healthy once the canary MIG is torn down,
+// which would otherwise make the final step's analysis window a no-op.
+func TestRunnerStart_TrafficStrategy_FinalStepFailsAnalysis(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+	fake.unhealthyTemplate = canary.SelfLink
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{TrafficWeight: 100},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+		Rollback:            true,
+	}
+
+	runner := newTestRunner(fake, StrategyTraffic, plan)
+
+	if err := runner.Start(context.Background()); err == nil {
+		t.Fatal("Start() expected an error after the final step failed analysis, got nil")
+	}
+
+	final := fake.migs["web"]
+
+	if len(final.Versions) != 1 || final.Versions[0].InstanceTemplate != primary.SelfLink {
+		t.Fatalf(
+			"expected primary instance group to be rolled back instead of promoted, got versions: %+v",
+			final.Versions,
+		)
+	}
+
+	if fake.backend.Backends[0].CapacityScaler != 1.0 {
+		t.Fatalf(
+			"expected primary backend weight to be fully restored after rollback, got scaler %.2f",
+			fake.backend.Backends[0].CapacityScaler,
+		)
+	}
+}
+
+// TestRunnerStart_TrafficStrategy_PromotionPreservesUnrelatedBackends covers
+// promotion when a backend service fronts the instance group alongside an
+// unrelated backend that bulldozer did not create; promoting the canary
+// template must not drop it from the backend service.
+func TestRunnerStart_TrafficStrategy_PromotionPreservesUnrelatedBackends(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:               "web",
+		SelfLink:           "mig/web",
+		TargetSize:         4,
+		InstanceTemplate:   primary.SelfLink,
+		DistributionPolicy: &compute.DistributionPolicy{Zones: []*compute.DistributionPolicyZoneConfiguration{{}}},
+		Versions:           []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+
+	unrelated := &compute.Backend{
+		Group:          "mig/dr-pool",
+		BalancingMode:  "UTILIZATION",
+		CapacityScaler: 1.0,
+	}
+	fake.backend.Backends = append(fake.backend.Backends, unrelated)
+
+	plan := &Plan{
+		Steps: []PlanStep{
+			{TrafficWeight: 100},
+		},
+		MaxUnhealthyPercent: 0,
+		FailureThreshold:    1,
+	}
+
+	runner := newTestRunner(fake, StrategyTraffic, plan)
+
+	if err := runner.Start(context.Background()); err != nil {
+		t.Fatalf("Start() returned unexpected error: %s", err)
+	}
+
+	found := false
+
+	for _, b := range fake.backend.Backends {
+		if b.Group == unrelated.Group {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatal("expected unrelated backend entry to survive canary promotion")
+	}
+}
+
+func TestRunner_CheckDrift(t *testing.T) {
+	primary := &compute.InstanceTemplate{SelfLink: "template/web-v1"}
+	canary := &compute.InstanceTemplate{SelfLink: "template/web-v2"}
+
+	group := &compute.InstanceGroupManager{
+		Name:     "web",
+		SelfLink: "mig/web",
+		Versions: []*compute.InstanceGroupManagerVersion{{InstanceTemplate: primary.SelfLink}},
+	}
+
+	fake := newFakeComputeAPI(primary, canary, group)
+	runner := newTestRunner(fake, StrategyInstances, &Plan{Steps: []PlanStep{{}}})
+	info := clusterInfo{group: group, template: canary}
+
+	if err := runner.checkDrift(info, &State{PrimaryTemplate: primary.SelfLink}); err != nil {
+		t.Errorf("expected no drift error when templates match, got: %s", err)
+	}
+
+	if err := runner.checkDrift(info, &State{PrimaryTemplate: "template/web-v0"}); err == nil {
+		t.Error("expected a drift error when the recorded primary template differs from the live one")
+	}
+}
@@ -0,0 +1,188 @@
+package statemachine
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+)
+
+// State is the rollout checkpoint persisted by a StateStore after each
+// completed plan step, so a killed or preempted run can be resumed instead
+// of restarting canary sizing from scratch.
+type State struct {
+	ProjectID    string       `json:"projectID"`
+	Location     RegionOrZone `json:"location"`
+	MIGName      string       `json:"migName"`
+	TemplateName string       `json:"templateName"`
+	Strategy     Strategy     `json:"strategy"`
+
+	// PrimaryTemplate is the non-canary template that was running at
+	// checkpoint time, used by Resume to detect drift (e.g. someone
+	// manually changed the instance group's template) before continuing.
+	PrimaryTemplate string `json:"primaryTemplate"`
+
+	// StepIndex is the plan step to resume at.
+	StepIndex int `json:"stepIndex"`
+
+	// Attempt is incremented every time a rollout resumes from a
+	// checkpoint, so a StateStore's history can distinguish retries of the
+	// same rollout.
+	Attempt int64 `json:"attempt"`
+}
+
+// StateStore persists and retrieves a rollout's checkpoint.
+type StateStore interface {
+	Save(ctx context.Context, state *State) error
+	Load(ctx context.Context) (*State, error)
+}
+
+var _ StateStore = (*localFileStateStore)(nil)
+
+type localFileStateStore struct {
+	path string
+}
+
+// NewLocalFileStateStore returns a StateStore that checkpoints to a local
+// JSON file at path.
+func NewLocalFileStateStore(path string) StateStore {
+	return &localFileStateStore{path: path}
+}
+
+func (s *localFileStateStore) Save(ctx context.Context, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+
+	if err != nil {
+		return errors.Wrap(err, "error marshaling rollout state")
+	}
+
+	return errors.Wrap(os.WriteFile(s.path, data, 0644), "error writing rollout state file")
+}
+
+func (s *localFileStateStore) Load(ctx context.Context) (*State, error) {
+	data, err := os.ReadFile(s.path)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading rollout state file")
+	}
+
+	var state State
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrap(err, "error parsing rollout state file")
+	}
+
+	return &state, nil
+}
+
+var _ StateStore = (*gcsStateStore)(nil)
+
+type gcsStateStore struct {
+	client *storage.Client
+	bucket string
+	object string
+}
+
+// NewGCSStateStore returns a StateStore that checkpoints to the GCS object
+// gs://bucket/object.
+func NewGCSStateStore(ctx context.Context, bucket, object string) (StateStore, error) {
+	client, err := storage.NewClient(ctx)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error initializing Google Cloud Storage client")
+	}
+
+	return &gcsStateStore{client: client, bucket: bucket, object: object}, nil
+}
+
+func (s *gcsStateStore) Save(ctx context.Context, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+
+	if err != nil {
+		return errors.Wrap(err, "error marshaling rollout state")
+	}
+
+	writer := s.client.Bucket(s.bucket).Object(s.object).NewWriter(ctx)
+
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+
+		return errors.Wrap(err, "error writing rollout state object")
+	}
+
+	return errors.Wrap(writer.Close(), "error finalizing rollout state object")
+}
+
+func (s *gcsStateStore) Load(ctx context.Context) (*State, error) {
+	reader, err := s.client.Bucket(s.bucket).Object(s.object).NewReader(ctx)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading rollout state object")
+	}
+
+	defer reader.Close()
+
+	var state State
+
+	if err := json.NewDecoder(reader).Decode(&state); err != nil {
+		return nil, errors.Wrap(err, "error parsing rollout state object")
+	}
+
+	return &state, nil
+}
+
+// ParseGCSPath splits a gs://bucket/object path into its bucket and object
+// components.
+func ParseGCSPath(path string) (bucket, object string, err error) {
+	trimmed := strings.TrimPrefix(path, "gs://")
+
+	if trimmed == path {
+		return "", "", errors.Errorf("invalid Google Cloud Storage path '%s', expected a gs:// URL", path)
+	}
+
+	parts := strings.SplitN(trimmed, "/", 2)
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.Errorf("invalid Google Cloud Storage path '%s', expected gs://bucket/object", path)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// Resume reconstructs a Runner from a checkpoint previously saved to store,
+// reconciling it against the live instance group's current template before
+// continuing from the recorded step. If the primary template has drifted
+// from the checkpoint, an error is returned unless force is true.
+func Resume(ctx context.Context, store StateStore, plan *Plan, force bool) (*Runner, error) {
+	state, err := store.Load(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	runner, err := New(ctx, state.ProjectID, state.Location, state.MIGName, state.TemplateName, state.Strategy, plan)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := runner.getInfo(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := runner.checkDrift(info, state); err != nil {
+		if !force {
+			return nil, err
+		}
+
+		log.Printf("%s (continuing because resume was forced)", err)
+	}
+
+	return runner.WithStateStore(store).ResumeFrom(state.StepIndex, state.Attempt), nil
+}
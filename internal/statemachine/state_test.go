@@ -0,0 +1,73 @@
+package statemachine
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalFileStateStore_SaveAndLoad(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	store := NewLocalFileStateStore(path)
+
+	want := &State{
+		ProjectID:       "my-project",
+		Location:        Zone("us-central1-a"),
+		MIGName:         "web",
+		TemplateName:    "web-v2",
+		Strategy:        StrategyInstances,
+		PrimaryTemplate: "web-v1",
+		StepIndex:       2,
+		Attempt:         1,
+	}
+
+	if err := store.Save(ctx, want); err != nil {
+		t.Fatalf("unexpected error saving state: %s", err)
+	}
+
+	got, err := store.Load(ctx)
+
+	if err != nil {
+		t.Fatalf("unexpected error loading state: %s", err)
+	}
+
+	if *got != *want {
+		t.Errorf("expected loaded state %+v, got %+v", want, got)
+	}
+}
+
+func TestLocalFileStateStore_LoadMissingFile(t *testing.T) {
+	store := NewLocalFileStateStore(filepath.Join(t.TempDir(), "does-not-exist.json"))
+
+	if _, err := store.Load(context.Background()); err == nil {
+		t.Fatal("expected an error loading a nonexistent state file")
+	}
+}
+
+func TestParseGCSPath(t *testing.T) {
+	bucket, object, err := ParseGCSPath("gs://my-bucket/rollouts/web.json")
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if bucket != "my-bucket" {
+		t.Errorf("expected bucket 'my-bucket', got '%s'", bucket)
+	}
+
+	if object != "rollouts/web.json" {
+		t.Errorf("expected object 'rollouts/web.json', got '%s'", object)
+	}
+}
+
+func TestParseGCSPath_Invalid(t *testing.T) {
+	cases := []string{"my-bucket/web.json", "gs://my-bucket", "gs://"}
+
+	for _, path := range cases {
+		if _, _, err := ParseGCSPath(path); err == nil {
+			t.Errorf("expected an error for invalid GCS path '%s'", path)
+		}
+	}
+}
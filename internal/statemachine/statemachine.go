@@ -14,6 +14,29 @@ const (
 	maxTicks     = 60
 )
 
+// Strategy selects how the canary template is rolled out to the primary
+// managed instance group.
+type Strategy string
+
+const (
+	// StrategyInstances grows the canary instance count within the primary
+	// managed instance group, setting it to each plan step's CanarySize in
+	// turn.
+	StrategyInstances Strategy = "instances"
+
+	// StrategyTraffic keeps the canary at a small, fixed instance count in a
+	// separate managed instance group and instead shifts load balancer
+	// traffic to it progressively by weighting backends on the discovered
+	// backend service.
+	StrategyTraffic Strategy = "traffic"
+
+	// StrategyBlueGreen provisions a sibling "green" instance group on the
+	// new template and atomically cuts backend service traffic over to it,
+	// rather than incrementally rolling out within the existing group. See
+	// NewBlueGreen.
+	StrategyBlueGreen Strategy = "blueGreen"
+)
+
 type Runner struct {
 	computeAPI computeAPI
 	sleepFunc  func(duration time.Duration)
@@ -22,9 +45,30 @@ type Runner struct {
 	location     RegionOrZone
 	migName      string
 	templateName string
+	strategy     Strategy
+	plan         *Plan
+
+	// soakDuration and keepOldMIG are only used by the blue/green strategy.
+	soakDuration time.Duration
+	keepOldMIG   bool
+
+	// stateStore, attempt, and resumeStepIndex support checkpointing and
+	// resuming a plan-driven rollout. See WithStateStore and ResumeFrom.
+	stateStore      StateStore
+	attempt         int64
+	resumeStepIndex int
+
+	// backendServiceOverride and urlMapOverride pin backend service
+	// discovery instead of the aggregated-list scan in getInfo. See
+	// WithBackendServiceOverride.
+	backendServiceOverride string
+	urlMapOverride         string
 }
 
-func New(ctx context.Context, projectID string, location RegionOrZone, migName, templateName string) (*Runner, error) {
+func New(
+	ctx context.Context,
+	projectID string, location RegionOrZone, migName, templateName string, strategy Strategy, plan *Plan,
+) (*Runner, error) {
 	computeAPI, err := newGoogleComputeAPI(ctx)
 
 	if err != nil {
@@ -39,53 +83,123 @@ func New(ctx context.Context, projectID string, location RegionOrZone, migName,
 		location:     location,
 		migName:      migName,
 		templateName: templateName,
+		strategy:     strategy,
+		plan:         plan,
+
+		attempt: 1,
 	}, nil
 }
 
-type clusterInfo struct {
-	group    *compute.InstanceGroupManager
-	template *compute.InstanceTemplate
-	backend  *compute.BackendService
+// WithStateStore enables checkpointing: after each successfully completed
+// plan step, the rollout's progress is saved to store so that a killed or
+// preempted run can be continued with Resume instead of restarting.
+func (r *Runner) WithStateStore(store StateStore) *Runner {
+	r.stateStore = store
+
+	return r
 }
 
-func (r *Runner) Start(ctx context.Context) error {
-	log.Printf("starting rollout of template '%s' to managed instance group '%s'", r.templateName, r.migName)
+// WithBackendServiceOverride pins backend service discovery to a specific
+// backend service name or URL map, instead of the aggregated-list scan
+// getInfo otherwise performs, which can be slow and brittle on large
+// projects. At most one of backendService or urlMap should be set.
+func (r *Runner) WithBackendServiceOverride(backendService, urlMap string) *Runner {
+	r.backendServiceOverride = backendService
+	r.urlMapOverride = urlMap
 
-	clusterInfo, err := r.getInfo(ctx)
+	return r
+}
+
+// ResumeFrom configures the runner to start the plan at stepIndex, as
+// recorded in a checkpoint, instead of from the beginning, and carries
+// forward the checkpoint's attempt counter.
+func (r *Runner) ResumeFrom(stepIndex int, attempt int64) *Runner {
+	r.resumeStepIndex = stepIndex
+	r.attempt = attempt + 1
+
+	return r
+}
+
+// checkDrift compares the live instance group's primary template against
+// the one recorded in state, returning an error if they differ (e.g.
+// someone manually changed the template out from under a paused rollout).
+func (r *Runner) checkDrift(info clusterInfo, state *State) error {
+	if state.PrimaryTemplate == "" {
+		return nil
+	}
+
+	primaryTemplate, _, err := r.findCanaryState(info)
 
 	if err != nil {
 		return err
 	}
 
-	loopIterations := 0
+	if primaryTemplate != state.PrimaryTemplate {
+		return errors.Errorf(
+			"instance group's primary template has drifted since checkpoint: recorded '%s', now '%s'",
+			state.PrimaryTemplate, primaryTemplate,
+		)
+	}
 
-	for {
-		log.Printf("beginning rollout loop iteration #%d", loopIterations)
+	return nil
+}
 
-		if err := r.scale(ctx, clusterInfo); err != nil {
-			return err
-		}
+// checkpoint saves the rollout's progress through stepIndex to the
+// configured state store, if any. It is a no-op when no store has been
+// configured via WithStateStore.
+func (r *Runner) checkpoint(ctx context.Context, info clusterInfo, stepIndex int) error {
+	if r.stateStore == nil {
+		return nil
+	}
 
-		clusterInfo, err = r.waitUntilStable(ctx, clusterInfo)
+	// The primary template can no longer be determined once the rollout has
+	// fully promoted the canary, since at that point there is nothing left
+	// to distinguish it from; that's fine, since a completed rollout has no
+	// drift to detect on resume.
+	primaryTemplate, _, _ := r.findCanaryState(info)
+
+	state := &State{
+		ProjectID:       r.projectID,
+		Location:        r.location,
+		MIGName:         r.migName,
+		TemplateName:    r.templateName,
+		Strategy:        r.strategy,
+		PrimaryTemplate: primaryTemplate,
+		StepIndex:       stepIndex + 1,
+		Attempt:         r.attempt,
+	}
 
-		if err != nil {
-			return err
-		}
+	return errors.Wrap(r.stateStore.Save(ctx, state), "error checkpointing rollout state")
+}
 
-		if err := r.checkBackendServiceHealth(ctx, clusterInfo); err != nil {
-			return err
-		}
+type clusterInfo struct {
+	group    *compute.InstanceGroupManager
+	template *compute.InstanceTemplate
 
-		if r.isDone(clusterInfo) {
-			break
-		}
+	// backends holds every backend service that references the managed
+	// instance group, since a single MIG can sit behind more than one (e.g.
+	// path-based routing or multi-region load balancers behind a URL map).
+	backends []*compute.BackendService
+
+	// canary is only populated for the traffic strategy, once the canary
+	// instance group has been created.
+	canary *compute.InstanceGroupManager
+}
+
+func (r *Runner) Start(ctx context.Context) error {
+	log.Printf("starting rollout of template '%s' to managed instance group '%s'", r.templateName, r.migName)
+
+	clusterInfo, err := r.getInfo(ctx)
 
-		loopIterations++
+	if err != nil {
+		return err
 	}
 
-	log.Printf("rollout complete")
+	if r.strategy == StrategyBlueGreen {
+		return r.startBlueGreen(ctx, clusterInfo)
+	}
 
-	return nil
+	return r.runPlan(ctx, clusterInfo)
 }
 
 func (r *Runner) getInfo(ctx context.Context) (clusterInfo, error) {
@@ -103,28 +217,55 @@ func (r *Runner) getInfo(ctx context.Context) (clusterInfo, error) {
 		return emptyInfo, err
 	}
 
-	backend, err := r.computeAPI.FindBackendServiceWithMIG(ctx, r.projectID, group)
+	backends, err := r.discoverBackendServices(ctx, group)
 
 	if err != nil {
 		return emptyInfo, err
 	}
 
-	return clusterInfo{group, template, backend}, nil
+	return clusterInfo{group: group, template: template, backends: backends}, nil
 }
 
-func (r *Runner) scale(ctx context.Context, info clusterInfo) error {
-	var primaryTemplate string
-	var oldCanarySize int64
-	var newCanarySize int64
+// discoverBackendServices resolves the backend services fronting group,
+// either by querying the operator-pinned --backend-service or --url-map
+// override, or by falling back to an aggregated-list scan across the
+// project.
+func (r *Runner) discoverBackendServices(
+	ctx context.Context, group *compute.InstanceGroupManager,
+) ([]*compute.BackendService, error) {
+	switch {
+	case r.backendServiceOverride != "":
+		backend, err := r.computeAPI.GetBackendService(ctx, r.projectID, r.backendServiceOverride)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []*compute.BackendService{backend}, nil
+
+	case r.urlMapOverride != "":
+		backend, err := r.computeAPI.GetURLMapDefaultBackendService(ctx, r.projectID, r.urlMapOverride)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []*compute.BackendService{backend}, nil
+
+	default:
+		return r.computeAPI.FindBackendServicesWithMIG(ctx, r.projectID, group)
+	}
+}
 
-	// TODO should this reset to zero on the very first scale() call, even if a
-	// canary template is found?
+// findCanaryState scans a managed instance group's versions for the primary
+// (non-canary) template and the current canary instance count, if any.
+func (r *Runner) findCanaryState(info clusterInfo) (primaryTemplate string, canarySize int64, err error) {
 	for _, version := range info.group.Versions {
 		if version.InstanceTemplate == info.template.SelfLink {
-			if version.TargetSize.Fixed > 0 {
-				oldCanarySize = version.TargetSize.Fixed
+			if version.TargetSize != nil && version.TargetSize.Fixed > 0 {
+				canarySize = version.TargetSize.Fixed
 
-				log.Printf("found existing canary deployment with %d instances", oldCanarySize)
+				log.Printf("found existing canary deployment with %d instances", canarySize)
 			}
 
 			continue
@@ -132,7 +273,7 @@ func (r *Runner) scale(ctx context.Context, info clusterInfo) error {
 
 		if version.Name != "canary" {
 			if primaryTemplate != "" {
-				return errors.Errorf(
+				return "", 0, errors.Errorf(
 					"found two non-canary templates: '%s' and '%s', cannot determine primary template",
 					primaryTemplate,
 					version.InstanceTemplate,
@@ -144,36 +285,40 @@ func (r *Runner) scale(ctx context.Context, info clusterInfo) error {
 	}
 
 	if primaryTemplate == "" {
-		return errors.New("could not find primary (non-canary) template")
+		return "", 0, errors.New("could not find primary (non-canary) template")
 	}
 
-	if oldCanarySize == 0 {
-		newCanarySize = 1
-	} else {
-		newCanarySize = oldCanarySize * 2
+	return primaryTemplate, canarySize, nil
+}
+
+// applyInstancesStep patches the primary managed instance group so that the
+// canary template runs on step.CanarySize instances (capped at the group's
+// target size), keeping the primary template on the rest. Promoting the canary to the group's sole
+// version, once step.CanarySize reaches the group's target size, is left to
+// finalizeInstancesStep, which the caller must only invoke once analysis for
+// this step has passed; applying a version set that still distinguishes a
+// primary template keeps findCanaryState working for both analysis and a
+// potential rollback.
+func (r *Runner) applyInstancesStep(ctx context.Context, info clusterInfo, step PlanStep) (clusterInfo, error) {
+	primaryTemplate, oldCanarySize, err := r.findCanaryState(info)
+
+	if err != nil {
+		return info, err
 	}
 
-	var versions []*compute.InstanceGroupManagerVersion
+	newCanarySize := step.CanarySize
 
-	if newCanarySize >= info.group.TargetSize {
+	if newCanarySize > info.group.TargetSize {
 		newCanarySize = info.group.TargetSize
+	}
 
-		versions = []*compute.InstanceGroupManagerVersion{
-			&compute.InstanceGroupManagerVersion{
-				InstanceTemplate: info.template.SelfLink,
-			},
-		}
-	} else {
-		versions = []*compute.InstanceGroupManagerVersion{
-			&compute.InstanceGroupManagerVersion{
-				InstanceTemplate: primaryTemplate,
-			},
-			&compute.InstanceGroupManagerVersion{
-				Name:             "canary",
-				InstanceTemplate: info.template.SelfLink,
-				TargetSize:       &compute.FixedOrPercent{Fixed: newCanarySize},
-			},
-		}
+	versions := []*compute.InstanceGroupManagerVersion{
+		{InstanceTemplate: primaryTemplate},
+		{
+			Name:             "canary",
+			InstanceTemplate: info.template.SelfLink,
+			TargetSize:       &compute.FixedOrPercent{Fixed: newCanarySize},
+		},
 	}
 
 	log.Printf("patching managed instance group with canary target of %d instances", newCanarySize)
@@ -181,11 +326,11 @@ func (r *Runner) scale(ctx context.Context, info clusterInfo) error {
 	maxSurge := newCanarySize - oldCanarySize
 	maxUnavailable := int64(0)
 
-	if maxSurge < int64(len(info.group.DistributionPolicy.Zones)) {
+	if maxSurge < zoneCount(info) {
 		// avoids 'Fixed updatePolicy.maxSurge for regional managed instance
 		// group has to be either 0 or at least equal to the number of zones.'
 		// errors
-		maxSurge = int64(len(info.group.DistributionPolicy.Zones))
+		maxSurge = zoneCount(info)
 	}
 
 	patch := &compute.InstanceGroupManager{
@@ -198,76 +343,82 @@ func (r *Runner) scale(ctx context.Context, info clusterInfo) error {
 	}
 
 	if err := r.computeAPI.PatchMIG(ctx, r.projectID, r.location, r.migName, patch); err != nil {
-		return errors.Wrap(err, "error updating instance templates in instance group")
+		return info, errors.Wrap(err, "error updating instance templates in instance group")
 	}
 
-	return nil
+	return info, nil
 }
 
-func (r *Runner) waitUntilStable(ctx context.Context, info clusterInfo) (clusterInfo, error) {
-	var emptyInfo clusterInfo
-
-	log.Printf("beginning wait until stable loop")
-
-	for ticks := 0; ticks < maxTicks; ticks++ {
-		refreshedGroup, err := r.computeAPI.GetMIG(ctx, r.projectID, r.location, r.migName)
-
-		if err != nil {
-			return emptyInfo, err
-		}
+// zoneCount returns the number of zones info.group's DistributionPolicy
+// spans, or 0 for a zonal instance group, which never populates it (it is a
+// region-only concept in the GCE API).
+func zoneCount(info clusterInfo) int64 {
+	if info.group.DistributionPolicy == nil {
+		return 0
+	}
 
-		if refreshedGroup.Status.IsStable && refreshedGroup.Status.VersionTarget.IsReached {
-			log.Printf("cluster is stable")
+	return int64(len(info.group.DistributionPolicy.Zones))
+}
 
-			return clusterInfo{refreshedGroup, info.template, info.backend}, nil
-		}
+// finalizeInstancesStep collapses the managed instance group down to a
+// single version on the canary template, once a step that reached the
+// group's target size has passed analysis.
+func (r *Runner) finalizeInstancesStep(ctx context.Context, info clusterInfo) (clusterInfo, error) {
+	log.Printf("promoting canary template to sole version on instance group '%s'", r.migName)
 
-		log.Printf("cluster is still not stable, sleeping")
+	patch := &compute.InstanceGroupManager{
+		Versions: []*compute.InstanceGroupManagerVersion{
+			{InstanceTemplate: info.template.SelfLink},
+		},
+		UpdatePolicy: &compute.InstanceGroupManagerUpdatePolicy{
+			Type:           "PROACTIVE",
+			MaxSurge:       &compute.FixedOrPercent{Fixed: zoneCount(info)},
+			MaxUnavailable: &compute.FixedOrPercent{Fixed: 0},
+		},
+	}
 
-		r.sleepFunc(tickInterval)
+	if err := r.computeAPI.PatchMIG(ctx, r.projectID, r.location, r.migName, patch); err != nil {
+		return info, errors.Wrap(err, "error promoting canary template on instance group")
 	}
 
-	return emptyInfo, errors.Errorf("cluster did not become stable within %d ticks", maxTicks)
+	return info, nil
 }
 
-func (r *Runner) checkBackendServiceHealth(ctx context.Context, info clusterInfo) error {
-	log.Printf("checking backend service health")
-
-	healthResponse, err := r.computeAPI.GetBackendServiceGroupHealth(ctx, r.projectID, info.backend, info.group)
+func (r *Runner) waitUntilStable(ctx context.Context, info clusterInfo) (clusterInfo, error) {
+	refreshedGroup, err := r.waitForMIGStable(ctx, r.migName)
 
 	if err != nil {
-		return err
+		var emptyInfo clusterInfo
+
+		return emptyInfo, err
 	}
 
-	isUnhealthy := make(map[string]bool)
+	return clusterInfo{group: refreshedGroup, template: info.template, backends: info.backends, canary: info.canary}, nil
+}
 
-	for _, healthStatus := range healthResponse.HealthStatus {
-		if healthStatus.HealthState == "UNHEALTHY" {
-			isUnhealthy[healthStatus.Instance] = true
-		}
-	}
+// waitForMIGStable polls the named managed instance group until it reports
+// itself stable and at its target version, or returns an error after
+// maxTicks attempts.
+func (r *Runner) waitForMIGStable(ctx context.Context, migName string) (*compute.InstanceGroupManager, error) {
+	log.Printf("beginning wait until stable loop for '%s'", migName)
 
-	log.Printf("found %d unhealthy instances", len(isUnhealthy))
+	for ticks := 0; ticks < maxTicks; ticks++ {
+		refreshedGroup, err := r.computeAPI.GetMIG(ctx, r.projectID, r.location, migName)
 
-	instances, err := r.computeAPI.GetMIGInstances(ctx, r.projectID, r.location, r.migName)
+		if err != nil {
+			return nil, err
+		}
 
-	if err != nil {
-		return err
-	}
+		if refreshedGroup.Status.IsStable && refreshedGroup.Status.VersionTarget.IsReached {
+			log.Printf("'%s' is stable", migName)
 
-	for _, instance := range instances {
-		if instance.Version.InstanceTemplate == info.template.SelfLink && isUnhealthy[instance.Instance] {
-			return errors.Errorf("found unhealthy canary instance in backend service: '%s'", instance.Instance)
+			return refreshedGroup, nil
 		}
-	}
 
-	return nil
-}
+		log.Printf("'%s' is still not stable, sleeping", migName)
 
-func (r *Runner) isDone(info clusterInfo) bool {
-	if len(info.group.Versions) == 1 && info.group.Versions[0].InstanceTemplate == info.template.SelfLink {
-		return true
+		r.sleepFunc(tickInterval)
 	}
 
-	return false
+	return nil, errors.Errorf("'%s' did not become stable within %d ticks", migName, maxTicks)
 }
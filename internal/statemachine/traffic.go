@@ -0,0 +1,226 @@
+package statemachine
+
+import (
+	"context"
+	"log"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/compute/v1"
+)
+
+const canaryMIGSuffix = "-canary"
+
+func canaryMIGName(migName string) string {
+	return migName + canaryMIGSuffix
+}
+
+// applyTrafficStep shifts the discovered backend service's traffic to
+// step.TrafficWeight percent on the canary instance group, creating it on
+// the first call. Reaching a weight of 100 does not promote the canary here
+// — the caller must run analysis against this pre-promotion state first and
+// only then call finalizeTrafficStep.
+func (r *Runner) applyTrafficStep(ctx context.Context, info clusterInfo, step PlanStep) (clusterInfo, error) {
+	canaryGroup := info.canary
+
+	if canaryGroup == nil {
+		created, err := r.getOrCreateCanaryMIG(ctx, info, canaryMIGName(r.migName))
+
+		if err != nil {
+			return info, err
+		}
+
+		if canaryGroup, err = r.waitForMIGStable(ctx, created.Name); err != nil {
+			return info, err
+		}
+	}
+
+	info.canary = canaryGroup
+
+	log.Printf("shifting %d%% of traffic to canary instance group '%s'", step.TrafficWeight, canaryGroup.Name)
+
+	if err := r.setCanaryWeight(ctx, info, canaryGroup, step.TrafficWeight); err != nil {
+		return info, err
+	}
+
+	return info, nil
+}
+
+// finalizeTrafficStep promotes the canary template to primary and tears down
+// the now-unneeded canary instance group. It must only be called once a step
+// that reached 100% traffic weight has passed analysis.
+func (r *Runner) finalizeTrafficStep(ctx context.Context, info clusterInfo) (clusterInfo, error) {
+	canaryGroup := info.canary
+
+	log.Printf("promoting canary template '%s' to primary instance group '%s'", r.templateName, r.migName)
+
+	if err := r.promoteCanaryTemplate(ctx, info); err != nil {
+		return info, err
+	}
+
+	log.Printf("tearing down canary instance group '%s'", canaryGroup.Name)
+
+	if err := r.computeAPI.DeleteMIG(ctx, r.projectID, r.location, canaryGroup.Name); err != nil {
+		return info, errors.Wrap(err, "error deleting canary instance group")
+	}
+
+	info.canary = nil
+
+	return info, nil
+}
+
+// rollbackTraffic reverses the traffic strategy by draining the canary
+// instance group back to zero weight and deleting it, leaving the primary
+// instance group and its original template untouched.
+func (r *Runner) rollbackTraffic(ctx context.Context, info clusterInfo) error {
+	if info.canary == nil {
+		return nil
+	}
+
+	if err := r.setCanaryWeight(ctx, info, info.canary, 0); err != nil {
+		return err
+	}
+
+	if err := r.computeAPI.DeleteMIG(ctx, r.projectID, r.location, info.canary.Name); err != nil {
+		log.Printf("[WARN] error deleting canary instance group during rollback: %s", err)
+	}
+
+	return nil
+}
+
+func (r *Runner) getOrCreateCanaryMIG(
+	ctx context.Context, info clusterInfo, canaryName string,
+) (*compute.InstanceGroupManager, error) {
+	if existing, err := r.computeAPI.GetMIG(ctx, r.projectID, r.location, canaryName); err == nil {
+		log.Printf("found existing canary instance group '%s'", canaryName)
+
+		return existing, nil
+	}
+
+	log.Printf("creating canary instance group '%s'", canaryName)
+
+	canary := &compute.InstanceGroupManager{
+		Name:               canaryName,
+		BaseInstanceName:   canaryName,
+		TargetSize:         1,
+		InstanceTemplate:   info.template.SelfLink,
+		DistributionPolicy: info.group.DistributionPolicy,
+	}
+
+	if err := r.computeAPI.InsertMIG(ctx, r.projectID, r.location, canary); err != nil {
+		return nil, errors.Wrap(err, "error creating canary instance group")
+	}
+
+	created, err := r.computeAPI.GetMIG(ctx, r.projectID, r.location, canaryName)
+
+	return created, errors.Wrap(err, "error retrieving newly created canary instance group")
+}
+
+// setCanaryWeight patches every backend service fronting the instance group
+// so that the canary instance group receives the given percentage of
+// traffic, with the remainder going to the primary instance group.
+func (r *Runner) setCanaryWeight(
+	ctx context.Context, info clusterInfo, canaryGroup *compute.InstanceGroupManager, weight int64,
+) error {
+	for _, backend := range info.backends {
+		if err := r.setCanaryWeightOnBackend(ctx, backend, info.group, canaryGroup, weight); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) setCanaryWeightOnBackend(
+	ctx context.Context,
+	backend *compute.BackendService, primaryGroup, canaryGroup *compute.InstanceGroupManager, weight int64,
+) error {
+	canaryScaler := float64(weight) / 100.0
+
+	newBackends := make([]*compute.Backend, 0, len(backend.Backends)+1)
+	foundCanary := false
+
+	for _, b := range backend.Backends {
+		switch b.Group {
+		case canaryGroup.SelfLink:
+			b.BalancingMode = "UTILIZATION"
+			b.CapacityScaler = canaryScaler
+
+			foundCanary = true
+
+		case primaryGroup.SelfLink:
+			b.BalancingMode = "UTILIZATION"
+			b.CapacityScaler = 1 - canaryScaler
+		}
+
+		newBackends = append(newBackends, b)
+	}
+
+	if !foundCanary {
+		newBackends = append(newBackends, &compute.Backend{
+			Group:          canaryGroup.SelfLink,
+			BalancingMode:  "UTILIZATION",
+			CapacityScaler: canaryScaler,
+		})
+	}
+
+	patch := &compute.BackendService{Backends: newBackends}
+
+	if err := r.computeAPI.PatchBackendService(ctx, r.projectID, backend, patch); err != nil {
+		return errors.Wrap(err, "error patching backend service traffic weights")
+	}
+
+	backend.Backends = newBackends
+
+	return nil
+}
+
+// promoteCanaryTemplate replaces the primary instance group's template with
+// the canary template and restores every backend service fronting it to
+// full weight.
+func (r *Runner) promoteCanaryTemplate(ctx context.Context, info clusterInfo) error {
+	patch := &compute.InstanceGroupManager{
+		Versions: []*compute.InstanceGroupManagerVersion{
+			{InstanceTemplate: info.template.SelfLink},
+		},
+	}
+
+	if err := r.computeAPI.PatchMIG(ctx, r.projectID, r.location, r.migName, patch); err != nil {
+		return errors.Wrap(err, "error promoting canary template on primary instance group")
+	}
+
+	for _, backend := range info.backends {
+		if err := r.restorePrimaryWeightOnBackend(ctx, backend, info.group, info.canary); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restorePrimaryWeightOnBackend sets the primary instance group back to full
+// weight and drops the now-promoted canary entry, leaving every other
+// backend entry (anything not created by bulldozer) untouched.
+func (r *Runner) restorePrimaryWeightOnBackend(
+	ctx context.Context, backend *compute.BackendService, primaryGroup, canaryGroup *compute.InstanceGroupManager,
+) error {
+	newBackends := make([]*compute.Backend, 0, len(backend.Backends))
+
+	for _, b := range backend.Backends {
+		if b.Group == canaryGroup.SelfLink {
+			continue
+		}
+
+		if b.Group == primaryGroup.SelfLink {
+			b.BalancingMode = "UTILIZATION"
+			b.CapacityScaler = 1.0
+		}
+
+		newBackends = append(newBackends, b)
+	}
+
+	patch := &compute.BackendService{Backends: newBackends}
+
+	err := r.computeAPI.PatchBackendService(ctx, r.projectID, backend, patch)
+
+	return errors.Wrap(err, "error restoring primary backend weight after promotion")
+}